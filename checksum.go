@@ -0,0 +1,24 @@
+package filecache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+// ErrCorrupt is returned when an item's stored checksum does not match its
+// value, indicating bitrot or a partial write. The offending item is removed
+// from the cache before this error is returned, so callers can refetch from
+// source.
+var ErrCorrupt = errors.New("filecache: item checksum mismatch")
+
+// checksum returns the SHA-256 sum of data, used to detect bitrot in cached
+// item values.
+func checksum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+func checksumMatches(item *cacheItem) bool {
+	return bytes.Equal(checksum(item.Value), item.Checksum)
+}
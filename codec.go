@@ -0,0 +1,169 @@
+package filecache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ErrCodecMismatch is returned when a cache file on disk was written with a
+// different Codec than the one the opening Cacher is configured with.
+var ErrCodecMismatch = errors.New("filecache: cache file codec mismatch")
+
+// Codec defines how a Cacher serializes cached values to bytes and reads
+// them back. A Cacher's Codec also encodes the per-bucket snapshot written
+// to disk, so FileExt is recorded in a small header on every cache file,
+// letting a Cacher opened against an existing directory detect a mismatched
+// format instead of silently misreading the bytes.
+type Codec interface {
+	// Marshal appends the encoded form of v to dst and returns the
+	// extended buffer.
+	Marshal(dst []byte, v interface{}) ([]byte, error)
+	// Unmarshal decodes data into v.
+	Unmarshal(data []byte, v interface{}) error
+	// FileExt identifies the codec's format. It is recorded in a cache
+	// file's header, not necessarily the file's actual OS suffix.
+	FileExt() string
+}
+
+// JSONCodec is the default Codec. It encodes item values and bucket
+// snapshots as JSON.
+var JSONCodec Codec = jsonCodec{}
+
+// GobCodec encodes values with encoding/gob.
+var GobCodec Codec = gobCodec{}
+
+// MsgpackCodec encodes values as MessagePack, which is typically more
+// compact and faster to (de)serialize than JSON for the same data.
+var MsgpackCodec Codec = msgpackCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(dst []byte, v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(dst, b...), nil
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) FileExt() string {
+	return ".cache"
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(dst []byte, v interface{}) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func (gobCodec) FileExt() string {
+	return ".cache.gob"
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(dst []byte, v interface{}) ([]byte, error) {
+	b, err := msgpack.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(dst, b...), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+func (msgpackCodec) FileExt() string {
+	return ".cache.msgpack"
+}
+
+// CompressedCodec wraps another Codec and compresses its output with zstd.
+// This trades CPU time on every Set/Get for a smaller on-disk footprint,
+// which pays off for large cached payloads such as API responses or blobs.
+type CompressedCodec struct {
+	Codec Codec
+}
+
+func (c CompressedCodec) Marshal(dst []byte, v interface{}) ([]byte, error) {
+	encoded, err := c.Codec.Marshal(nil, v)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(dst)
+	zw, err := zstd.NewWriter(buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(encoded); err != nil {
+		_ = zw.Close()
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c CompressedCodec) Unmarshal(data []byte, v interface{}) error {
+	zr, err := zstd.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	decoded, err := io.ReadAll(zr)
+	if err != nil {
+		return err
+	}
+	return c.Codec.Unmarshal(decoded, v)
+}
+
+func (c CompressedCodec) FileExt() string {
+	return c.Codec.FileExt() + ".zst"
+}
+
+// Option configures a Cacher constructed via NewCacherWithOptions.
+type Option func(*Cacher)
+
+// WithCodec sets the Codec a Cacher uses to serialize cached values and
+// bucket snapshots. The default, used when no WithCodec option is given, is
+// JSONCodec.
+func WithCodec(codec Codec) Option {
+	return func(c *Cacher) {
+		c.codec = codec
+	}
+}
+
+// NewCacherWithOptions returns a Cacher configured via the given Options. It
+// behaves like NewCacher except that storage settings such as the Codec can
+// be customized.
+func NewCacherWithOptions(dir string, opts ...Option) (*Cacher, error) {
+	c, err := NewCacher(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
@@ -0,0 +1,80 @@
+package filecache
+
+import "sync/atomic"
+
+// CacheOp identifies the kind of access or change a CacheEvent reports.
+type CacheOp int
+
+const (
+	// OpSet is published whenever Set or SetFrozen stores a value.
+	OpSet CacheOp = iota
+	// OpGet is published whenever Get or GetFrozen returns a hit.
+	OpGet
+	// OpDelete is published whenever Delete removes a value.
+	OpDelete
+	// OpExpire is published whenever a read or housekeeping call finds and
+	// removes an expired item.
+	OpExpire
+	// OpEvict is published whenever enforceByteLimit removes an item to stay
+	// within a Cacher's maxBytes budget.
+	OpEvict
+)
+
+// CacheEvent describes a single cache access or mutation, published to every
+// subscriber registered via Subscribe.
+type CacheEvent struct {
+	Bucket string
+	Key    string
+	Op     CacheOp
+	Size   int64
+}
+
+// subscriberBuffer bounds how far a subscriber can lag behind before publish
+// starts dropping its oldest pending event to make room for the newest one,
+// so a slow subscriber can never block a cache operation.
+const subscriberBuffer = 256
+
+// Subscribe registers fn to be called, on a dedicated background goroutine,
+// for every CacheEvent this Cacher publishes (Set, Get, Delete, plus the
+// Expire/Evict housekeeping events). Events are buffered per subscriber; if
+// fn falls behind, the oldest buffered event is dropped to make room for the
+// newest one and the Cacher's EventsDropped stat increments, so a slow
+// subscriber can never block a cache operation. fn is never called
+// concurrently with itself.
+func (c *Cacher) Subscribe(fn func(event CacheEvent)) {
+	ch := make(chan CacheEvent, subscriberBuffer)
+
+	c.subMu.Lock()
+	c.subscribers = append(c.subscribers, ch)
+	c.subMu.Unlock()
+
+	go func() {
+		for event := range ch {
+			fn(event)
+		}
+	}()
+}
+
+// publish delivers event to every subscriber registered via Subscribe. A
+// subscriber whose buffer is full has its oldest pending event dropped to
+// make room, so a slow subscriber is never allowed to block the caller.
+func (c *Cacher) publish(event CacheEvent) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	for _, ch := range c.subscribers {
+		select {
+		case ch <- event:
+		default:
+			select {
+			case <-ch:
+			default:
+			}
+			select {
+			case ch <- event:
+			default:
+			}
+			atomic.AddUint64(&c.eventsDropped, 1)
+		}
+	}
+}
@@ -1,32 +1,82 @@
 package filecache
 
 import (
-	"encoding/json"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // CacheStore represents a single-process, file-based, key/value cache store.
 type CacheStore struct {
-	filePath string
-	mu       sync.Mutex
-	data     map[string]*cacheItem
+	// bucketName is the (already namespace-prefixed) bucket name this store
+	// backs, recorded so events published about it carry the right Bucket.
+	bucketName string
+	filePath   string
+	mu         sync.Mutex
+	data       map[string]*cacheItem
+	// codec (de)serializes both individual item values and the bucket
+	// snapshot written to disk. It is inherited from the owning Cacher.
+	codec Codec
+	// totalBytes is the sum of the serialized size of every item currently
+	// held by this store, kept up to date by Set/Delete/Get so the Cacher
+	// can enforce a byte budget without re-marshaling everything.
+	totalBytes int64
+
+	// walEnabled and walThreshold are inherited from the owning Cacher. When
+	// walEnabled is set, Set/Delete append to walFile instead of rewriting
+	// the whole snapshot, and saveToFile is only called once walSize crosses
+	// walThreshold (or on Close/CleanBucket/eviction).
+	walEnabled   bool
+	walThreshold int64
+	walPath      string
+	walFile      *os.File
+	walSize      int64
+
+	// calls tracks loads currently in flight via GetOrLoad, keyed by cache
+	// key, so concurrent callers requesting the same key collapse onto a
+	// single loader call instead of each hitting the upstream.
+	calls map[string]*call
 }
 
 // Bucket represents a cache bucket with a name and TTL.
 type Bucket struct {
-	name string
-	ttl  time.Duration
+	name   string
+	ttl    time.Duration
+	shards int
 }
 
 var Ext = ".cache"
 
 func NewBucket(name string, ttl time.Duration) Bucket {
-	return Bucket{name: name, ttl: ttl}
+	return Bucket{name: name, ttl: ttl, shards: 1}
+}
+
+// NewShardedBucket returns a Bucket that splits its keys across shards
+// separate files instead of one, so concurrent writes to different keys
+// proceed in parallel and each save rewrites only a fraction of the
+// bucket's data. shards below 1 is treated as 1 (unsharded).
+//
+// The returned Bucket only carries the shard count as data: it has no
+// effect by itself. The Cacher must be told about it via UseBucket before
+// the bucket's first Set/Get/etc. call, or the bucket silently opens
+// unsharded (shards == 1) with no error or log to say so. Every bucket
+// operation other than UseBucket takes just a bucket name and TTL, so it is
+// easy to construct a "sharded" Bucket here and never actually shard it by
+// forgetting that call.
+func NewShardedBucket(name string, ttl time.Duration, shards int) Bucket {
+	if shards < 1 {
+		shards = 1
+	}
+	return Bucket{name: name, ttl: ttl, shards: shards}
 }
 
 func (b *Bucket) Name() string {
@@ -37,270 +87,660 @@ func (b *Bucket) TTL() time.Duration {
 	return b.ttl
 }
 
-type Cacher struct {
+// Shards returns the number of shards the bucket was configured with via
+// NewShardedBucket, or 1 for a bucket created with NewBucket.
+func (b *Bucket) Shards() int {
+	return b.shards
+}
+
+// cacherCore holds a Cacher's actual storage, configuration, and stats. It is
+// shared (via pointer) between a root Cacher and every view of it returned by
+// Namespace, so namespaced views have no storage of their own: they are
+// purely a naming convenience layered on top of the same buckets, counters,
+// and subscribers as the Cacher they were derived from.
+type cacherCore struct {
 	dir    string
-	stores map[string]*CacheStore
+	stores map[string]*bucketGroup
 	mu     sync.Mutex
 	ext    string
+	codec  Codec
+
+	// pendingShards records the shard count a bucket should be created with
+	// the next time it is opened, set via UseBucket. It is only consulted
+	// when a bucket has no shard manifest on disk yet; once a bucket has
+	// been created, its shard count is fixed and re-detected from that
+	// manifest on every subsequent load.
+	pendingShards map[string]int
+
+	// maxBytes is the on-disk footprint budget enforced across all buckets.
+	// A value of 0 (the default, via NewCacher/NewCacherWithExt) disables
+	// eviction entirely.
+	maxBytes int64
+	policy   EvictionPolicy
+
+	// wal enables WAL mode (see NewCacherWithWAL): Set/Delete append to a
+	// per-bucket WAL file instead of rewriting the whole snapshot on every
+	// call, trading the cost of replaying the WAL on load for O(1) writes.
+	wal          bool
+	walThreshold int64
+
+	hits        uint64
+	misses      uint64
+	evictions   uint64
+	corruptions uint64
+
+	// subMu guards subscribers, and eventsDropped tracks how many times
+	// publish had to drop a buffered event for a lagging subscriber.
+	subMu         sync.Mutex
+	subscribers   []chan CacheEvent
+	eventsDropped uint64
+}
+
+// Cacher stores values on disk, organized into named buckets. A Cacher
+// returned by NewCacher (and friends) is a root Cacher; Namespace returns a
+// view sharing the same underlying cacherCore with every bucket name it is
+// given prefixed, so independent components can share one Cacher without
+// coordinating bucket names.
+type Cacher struct {
+	*cacherCore
+	// nsPrefix is prepended to every bucket name this Cacher is asked to
+	// operate on. It is empty for a root Cacher.
+	nsPrefix string
+}
+
+// EvictionPolicy selects which item is removed first once a Cacher's
+// maxBytes budget is exceeded.
+type EvictionPolicy int
+
+const (
+	// LFU evicts the least-frequently-accessed item first, breaking ties by
+	// oldest access time. This is the default policy.
+	LFU EvictionPolicy = iota
+	// LRU evicts the least-recently-accessed item first.
+	LRU
+)
+
+// Stats is a snapshot of a Cacher's cumulative hit/miss/eviction counters.
+type Stats struct {
+	Hits        uint64
+	Misses      uint64
+	Evictions   uint64
+	Corruptions uint64
+	// EventsDropped counts CacheEvents dropped because a Subscribe
+	// subscriber fell behind and its buffered channel was full.
+	EventsDropped uint64
 }
 
 type cacheItem struct {
-	Value      interface{} `json:"value"`
-	Expiration *time.Time  `json:"expiration,omitempty"`
+	// Value holds the item's value already encoded by the store's Codec, so
+	// reads only need to decode it into the caller's type and writes never
+	// need to touch the rest of the bucket.
+	Value       []byte     `json:"value"`
+	Expiration  *time.Time `json:"expiration,omitempty"`
+	AccessCount uint64     `json:"accessCount"`
+	LastAccess  time.Time  `json:"lastAccess"`
+	// Size is the length of Value in bytes, used for byte-budget accounting.
+	Size int64 `json:"size"`
+	// Checksum is the SHA-256 sum of Value, computed on Set and verified on
+	// read so bitrot or a stray partial write is caught instead of handing
+	// back (or silently caching) corrupted data.
+	Checksum []byte `json:"checksum,omitempty"`
 }
 
 func NewCacher(dir string) (*Cacher, error) {
 	_ = os.MkdirAll(dir, os.ModePerm)
-	return &Cacher{
-		stores: make(map[string]*CacheStore),
+	return &Cacher{cacherCore: &cacherCore{
+		stores: make(map[string]*bucketGroup),
 		dir:    dir,
 		ext:    Ext,
-	}, nil
+		codec:  JSONCodec,
+	}}, nil
 }
 
 func NewCacherWithExt(dir, ext string) (*Cacher, error) {
 	_ = os.MkdirAll(dir, os.ModePerm)
-	return &Cacher{
-		stores: make(map[string]*CacheStore),
+	return &Cacher{cacherCore: &cacherCore{
+		stores: make(map[string]*bucketGroup),
 		dir:    dir,
 		ext:    ext,
-	}, nil
+		codec:  JSONCodec,
+	}}, nil
 }
 
-// Close closes all the cache stores.
-func (c *Cacher) Close() error {
+// UseBucket registers bucket's shard count with the Cacher so the next time
+// bucket.Name() is opened (its first Set/Get/etc. call) it is created with
+// that many shards. It has no effect on a bucket that has already been
+// created: once chosen, a bucket's shard count is fixed on disk via a small
+// manifest file and re-detected from it on every subsequent load, regardless
+// of what is registered here.
+func (c *Cacher) UseBucket(bucket Bucket) {
+	if bucket.shards <= 1 {
+		return
+	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	for _, store := range c.stores {
-		if err := store.saveToFile(); err != nil {
-			return err
-		}
+	if c.pendingShards == nil {
+		c.pendingShards = make(map[string]int)
 	}
-	return nil
+	c.pendingShards[c.nsPrefix+bucket.name] = bucket.shards
+}
+
+// NewCacherWithLimits returns a Cacher that enforces a maximum total on-disk
+// footprint of maxBytes across all of its buckets. Once a Set would push the
+// total over maxBytes, items are evicted according to policy (LFU by
+// default, breaking ties by oldest access) until the store fits again.
+func NewCacherWithLimits(dir string, maxBytes int64, policy EvictionPolicy) (*Cacher, error) {
+	c, err := NewCacher(dir)
+	if err != nil {
+		return nil, err
+	}
+	c.maxBytes = maxBytes
+	c.policy = policy
+	return c, nil
+}
+
+// defaultWALThreshold is the WAL size, in bytes, at which a Cacher created
+// via NewCacherWithWAL rewrites a bucket's full snapshot and truncates its
+// WAL, used when walThreshold is 0 or negative.
+const defaultWALThreshold = 1 << 20 // 1 MiB
+
+// NewCacherWithWAL returns a Cacher where every bucket's Set and Delete calls
+// append a length-prefixed record to a `<bucket>.wal` file instead of
+// rewriting the whole snapshot, giving durable single-item writes without
+// the O(bucket size) rewrite cost Set otherwise pays as a bucket grows. The
+// full snapshot is rewritten, and the WAL truncated, on Close, CleanBucket,
+// eviction, or once the WAL exceeds walThreshold bytes (defaultWALThreshold
+// if walThreshold is 0 or negative). On load, the snapshot is replayed first
+// and the WAL applied on top of it.
+func NewCacherWithWAL(dir string, walThreshold int64) (*Cacher, error) {
+	c, err := NewCacher(dir)
+	if err != nil {
+		return nil, err
+	}
+	if walThreshold <= 0 {
+		walThreshold = defaultWALThreshold
+	}
+	c.wal = true
+	c.walThreshold = walThreshold
+	return c, nil
 }
 
-// getStore returns a cache store for the given bucket name and TTL.
-func (c *Cacher) getStore(name string) (*CacheStore, error) {
+// Stats returns a snapshot of the Cacher's cumulative hit/miss/eviction counters.
+func (c *Cacher) Stats() Stats {
+	return Stats{
+		Hits:          atomic.LoadUint64(&c.hits),
+		Misses:        atomic.LoadUint64(&c.misses),
+		Evictions:     atomic.LoadUint64(&c.evictions),
+		Corruptions:   atomic.LoadUint64(&c.corruptions),
+		EventsDropped: atomic.LoadUint64(&c.eventsDropped),
+	}
+}
+
+// sumTotalBytes returns the combined totalBytes of the given stores.
+func (c *Cacher) sumTotalBytes(stores []*CacheStore) int64 {
+	var total int64
+	for _, store := range stores {
+		store.mu.Lock()
+		total += store.totalBytes
+		store.mu.Unlock()
+	}
+	return total
+}
+
+// enforceByteLimit evicts items across all buckets until the combined
+// totalBytes of every store fits within c.maxBytes. Items are ranked by the
+// configured EvictionPolicy, least-valuable first, with ties broken by
+// oldest access time.
+func (c *Cacher) enforceByteLimit() {
+	if c.maxBytes <= 0 {
+		return
+	}
+
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	stores := make([]*CacheStore, 0, len(c.stores))
+	for _, group := range c.stores {
+		stores = append(stores, group.shards...)
+	}
+	c.mu.Unlock()
 
-	store, ok := c.stores[name]
-	if !ok {
-		store = &CacheStore{
-			filePath: filepath.Join(c.dir, name+".cache"),
-			data:     make(map[string]*cacheItem),
+	// Cheap common-case check: every Set/Delete keeps each store's
+	// totalBytes current, so the combined total can be read without
+	// touching store.data. Only fall through to materializing and sorting
+	// every cached item once that total actually exceeds c.maxBytes.
+	if c.sumTotalBytes(stores) <= c.maxBytes {
+		return
+	}
+
+	type candidate struct {
+		store *CacheStore
+		key   string
+		item  *cacheItem
+	}
+
+	for {
+		var total int64
+		candidates := make([]candidate, 0)
+		for _, store := range stores {
+			store.mu.Lock()
+			total += store.totalBytes
+			for key, item := range store.data {
+				candidates = append(candidates, candidate{store, key, item})
+			}
+			store.mu.Unlock()
 		}
-		if err := store.loadFromFile(); err != nil {
-			return nil, err
+
+		if total <= c.maxBytes || len(candidates) == 0 {
+			return
 		}
-		c.stores[name] = store
+
+		sort.Slice(candidates, func(i, j int) bool {
+			a, b := candidates[i].item, candidates[j].item
+			if c.policy == LRU {
+				return a.LastAccess.Before(b.LastAccess)
+			}
+			if a.AccessCount != b.AccessCount {
+				return a.AccessCount < b.AccessCount
+			}
+			return a.LastAccess.Before(b.LastAccess)
+		})
+
+		victim := candidates[0]
+		victim.store.mu.Lock()
+		evicted := false
+		var size int64
+		if cur, ok := victim.store.data[victim.key]; ok && cur == victim.item {
+			delete(victim.store.data, victim.key)
+			victim.store.totalBytes -= cur.Size
+			_ = victim.store.saveToFile()
+			evicted, size = true, cur.Size
+		}
+		victim.store.mu.Unlock()
+		if !evicted {
+			continue
+		}
+		atomic.AddUint64(&c.evictions, 1)
+		c.publish(CacheEvent{Bucket: victim.store.bucketName, Key: victim.key, Op: OpEvict, Size: size})
 	}
-	return store, nil
+}
+
+// Close closes all the cache stores.
+func (c *Cacher) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, group := range c.stores {
+		for _, store := range group.shards {
+			if err := store.saveToFile(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 // Set sets the value for the given key in the given bucket.
 func (c *Cacher) Set(bucketName string, ttl time.Duration, key string, value interface{}) error {
-	store, err := c.getStore(bucketName)
+	group, err := c.getGroup(bucketName)
+	if err != nil {
+		return err
+	}
+	store := group.shardFor(key)
+
+	valueBytes, err := c.codec.Marshal(nil, value)
 	if err != nil {
 		return err
 	}
+
 	store.mu.Lock()
-	defer store.mu.Unlock()
-	store.data[key] = &cacheItem{Value: value, Expiration: ToPtr(time.Now().Add(ttl))}
-	return store.saveToFile()
+	// An overwrite carries over the previous item's AccessCount instead of
+	// resetting it to 0, so a key refreshed via the GetOrLoad read-through
+	// pattern doesn't look freshly-unused to the LFU policy.
+	var accessCount uint64
+	if old, ok := store.data[key]; ok {
+		store.totalBytes -= old.Size
+		accessCount = old.AccessCount
+	}
+	item := &cacheItem{
+		Value:       valueBytes,
+		Expiration:  ToPtr(time.Now().Add(ttl)),
+		AccessCount: accessCount,
+		LastAccess:  time.Now(),
+		Size:        int64(len(valueBytes)),
+		Checksum:    checksum(valueBytes),
+	}
+	store.data[key] = item
+	store.totalBytes += int64(len(valueBytes))
+	err = store.persist(walRecord{Op: walOpSet, Key: key, Item: item})
+	store.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	c.publish(CacheEvent{Bucket: store.bucketName, Key: key, Op: OpSet, Size: item.Size})
+	c.enforceByteLimit()
+	return nil
 }
 
 func Range[T any](c *Cacher, bucketName string, f func(key string, value T) bool) error {
-	store, err := c.getStore(bucketName)
+	group, err := c.getGroup(bucketName)
 	if err != nil {
 		return err
 	}
+
+	for _, store := range group.shards {
+		stop, err := rangeShard(c, bucketName, store, f)
+		if err != nil {
+			return err
+		}
+		if stop {
+			break
+		}
+	}
+	return nil
+}
+
+// rangeShard runs Range's loop body against a single shard, returning
+// whether the caller's f asked to stop iterating further shards. The
+// snapshot is only rewritten if an item was actually expired or evicted
+// during the pass, so a plain read-only Range/GetAll on a WAL-mode Cacher
+// doesn't pay the full snapshot rewrite the WAL exists to avoid.
+func rangeShard[T any](c *Cacher, bucketName string, store *CacheStore, f func(key string, value T) bool) (bool, error) {
 	store.mu.Lock()
 	defer store.mu.Unlock()
 
+	stop := false
+	changed := false
 	for key, item := range store.data {
 		if item.Expiration != nil && time.Now().After(*item.Expiration) {
+			store.totalBytes -= item.Size
+			delete(store.data, key)
+			changed = true
+			c.publish(CacheEvent{Bucket: store.bucketName, Key: key, Op: OpExpire, Size: item.Size})
+		} else if !checksumMatches(item) {
+			store.totalBytes -= item.Size
 			delete(store.data, key)
+			atomic.AddUint64(&c.corruptions, 1)
+			_ = store.saveToFile()
+			return false, fmt.Errorf("filecache: item %q in bucket %q: %w", key, bucketName, ErrCorrupt)
 		} else {
-			itemVal, err := json.Marshal(item.Value)
-			if err != nil {
-				return err
-			}
 			var out T
-			err = json.Unmarshal(itemVal, &out)
-			if err != nil {
-				return err
+			if err := store.codec.Unmarshal(item.Value, &out); err != nil {
+				return false, err
 			}
 			if !f(key, out) {
+				stop = true
 				break
 			}
 		}
 	}
 
-	return store.saveToFile()
+	if !changed {
+		return stop, nil
+	}
+	return stop, store.saveToFile()
 }
 
 // Get retrieves the value for the given key from the given bucket.
 // If the key does not exist or has expired, it returns false.
 // This removes the item from the cache if it has expired.
 func (c *Cacher) Get(bucketName string, key string, out interface{}) (bool, error) {
-	store, err := c.getStore(bucketName)
+	group, err := c.getGroup(bucketName)
 	if err != nil {
 		return false, err
 	}
+	store := group.shardFor(key)
 	store.mu.Lock()
 	defer store.mu.Unlock()
 	item, ok := store.data[key]
 	if !ok {
+		atomic.AddUint64(&c.misses, 1)
 		return false, nil
 	}
 	if item.Expiration != nil && time.Now().After(*item.Expiration) {
 		delete(store.data, key)
+		store.totalBytes -= item.Size
 		_ = store.saveToFile() // Ignore errors here
+		atomic.AddUint64(&c.misses, 1)
+		c.publish(CacheEvent{Bucket: store.bucketName, Key: key, Op: OpExpire, Size: item.Size})
 		return false, nil
 	}
-	data, err := json.Marshal(item.Value)
-	if err != nil {
-		return false, err
+	if !checksumMatches(item) {
+		delete(store.data, key)
+		store.totalBytes -= item.Size
+		atomic.AddUint64(&c.corruptions, 1)
+		_ = store.saveToFile()
+		return false, fmt.Errorf("filecache: item %q in bucket %q: %w", key, bucketName, ErrCorrupt)
 	}
-	return true, json.Unmarshal(data, out)
+	item.AccessCount++
+	item.LastAccess = time.Now()
+	atomic.AddUint64(&c.hits, 1)
+	c.publish(CacheEvent{Bucket: store.bucketName, Key: key, Op: OpGet, Size: item.Size})
+	return true, store.codec.Unmarshal(item.Value, out)
 }
 
 func GetAll[T any](c *Cacher, bucketName string) (map[string]T, error) {
-	store, err := c.getStore(bucketName)
-	if err != nil {
-		return nil, err
-	}
-
 	data := make(map[string]T)
-	err = Range(c, bucketName, func(key string, value T) bool {
+	err := Range(c, bucketName, func(key string, value T) bool {
 		data[key] = value
 		return true
 	})
 	if err != nil {
 		return nil, err
 	}
-
-	store.mu.Lock()
-	defer store.mu.Unlock()
-
-	return data, store.saveToFile()
+	return data, nil
 }
 
 // Delete deletes the value for the given key from the given bucket.
 func (c *Cacher) Delete(bucketName string, key string) error {
-	store, err := c.getStore(bucketName)
+	group, err := c.getGroup(bucketName)
 	if err != nil {
 		return err
 	}
+	store := group.shardFor(key)
 	store.mu.Lock()
 	defer store.mu.Unlock()
+	var size int64
+	if item, ok := store.data[key]; ok {
+		store.totalBytes -= item.Size
+		size = item.Size
+	}
 	delete(store.data, key)
-	return store.saveToFile()
+	if err := store.persist(walRecord{Op: walOpDelete, Key: key}); err != nil {
+		return err
+	}
+	c.publish(CacheEvent{Bucket: store.bucketName, Key: key, Op: OpDelete, Size: size})
+	return nil
 }
 
 func DeleteIf[T any](c *Cacher, bucketName string, cond func(key string, value T) bool) error {
-	store, err := c.getStore(bucketName)
+	group, err := c.getGroup(bucketName)
 	if err != nil {
 		return err
 	}
-	store.mu.Lock()
-	defer store.mu.Unlock()
 
-	for key, item := range store.data {
-		itemVal, err := json.Marshal(item.Value)
-		if err != nil {
-			return err
-		}
-		var out T
-		err = json.Unmarshal(itemVal, &out)
-		if err != nil {
+	for _, store := range group.shards {
+		if err := func() error {
+			store.mu.Lock()
+			defer store.mu.Unlock()
+
+			deleted := false
+			for key, item := range store.data {
+				var out T
+				if err := store.codec.Unmarshal(item.Value, &out); err != nil {
+					return err
+				}
+				if cond(key, out) {
+					store.totalBytes -= item.Size
+					delete(store.data, key)
+					deleted = true
+				}
+			}
+
+			if !deleted {
+				return nil
+			}
+			return store.saveToFile()
+		}(); err != nil {
 			return err
 		}
-		if cond(key, out) {
-			delete(store.data, key)
-		}
 	}
-
-	return store.saveToFile()
+	return nil
 }
 
 // EmptyBucket empties the given bucket (removes all items).
 func (c *Cacher) EmptyBucket(bucketName string) error {
-	store, err := c.getStore(bucketName)
+	group, err := c.getGroup(bucketName)
 	if err != nil {
 		return err
 	}
-	store.mu.Lock()
-	defer store.mu.Unlock()
-	store.data = make(map[string]*cacheItem)
-	return store.saveToFile()
+
+	for _, store := range group.shards {
+		store.mu.Lock()
+		store.data = make(map[string]*cacheItem)
+		store.totalBytes = 0
+		err := store.saveToFile()
+		store.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// RemoveBucket removes the given bucket.
+// RemoveBucket removes the given bucket, including every shard file it was
+// split across.
 func (c *Cacher) RemoveBucket(bucketName string) error {
+	bucketName = c.nsPrefix + bucketName
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if _, ok := c.stores[bucketName]; ok {
+	if group, ok := c.stores[bucketName]; ok {
+		for _, store := range group.shards {
+			if store.walFile != nil {
+				_ = store.walFile.Close()
+			}
+			_ = os.Remove(store.filePath)
+			if c.wal {
+				_ = os.Remove(store.walPath)
+			}
+		}
 		delete(c.stores, bucketName)
+		_ = os.Remove(filepath.Join(c.dir, bucketName+shardManifestExt))
+		return nil
+	}
+
+	// The bucket was never opened this run: fall back to removing whatever
+	// shard count its manifest (if any) claims, plus the unsharded default.
+	shards, _ := readShardManifest(filepath.Join(c.dir, bucketName+shardManifestExt))
+	if shards < 1 {
+		shards = 1
 	}
-	_ = os.Remove(filepath.Join(c.dir, bucketName+Ext))
+	for i := 0; i < shards; i++ {
+		_ = os.Remove(filepath.Join(c.dir, shardFileName(bucketName, i, shards)))
+		if c.wal {
+			_ = os.Remove(filepath.Join(c.dir, shardWALName(bucketName, i, shards)))
+		}
+	}
+	_ = os.Remove(filepath.Join(c.dir, bucketName+shardManifestExt))
 	return nil
 }
 
 func (c *Cacher) CleanBucket(bucketName string) error {
-	store, err := c.getStore(bucketName)
+	group, err := c.getGroup(bucketName)
 	if err != nil {
 		return err
 	}
-	store.mu.Lock()
-	defer store.mu.Unlock()
 
-	for key, item := range store.data {
-		if item.Expiration != nil && time.Now().After(*item.Expiration) {
-			delete(store.data, key)
+	for _, store := range group.shards {
+		store.mu.Lock()
+		for key, item := range store.data {
+			if item.Expiration != nil && time.Now().After(*item.Expiration) {
+				store.totalBytes -= item.Size
+				delete(store.data, key)
+				c.publish(CacheEvent{Bucket: store.bucketName, Key: key, Op: OpExpire, Size: item.Size})
+			}
+		}
+		err := store.saveToFile()
+		store.mu.Unlock()
+		if err != nil {
+			return err
 		}
 	}
-
-	return store.saveToFile()
+	return nil
 }
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
 // SetFrozen sets the item with no expiration in the given bucket.
 func (c *Cacher) SetFrozen(bucketName string, key string, value interface{}) error {
-	store, err := c.getStore(bucketName)
+	group, err := c.getGroup(bucketName)
 	if err != nil {
 		return err
 	}
+	store := group.shardFor(key)
+
+	valueBytes, err := c.codec.Marshal(nil, value)
+	if err != nil {
+		return err
+	}
+
 	store.mu.Lock()
-	defer store.mu.Unlock()
-	store.data[key] = &cacheItem{Value: value, Expiration: nil} // No expiration
-	return store.saveToFile()
+	// Carry over AccessCount on overwrite, same as Set, so refreshing a
+	// frozen item doesn't reset its LFU standing.
+	var accessCount uint64
+	if old, ok := store.data[key]; ok {
+		store.totalBytes -= old.Size
+		accessCount = old.AccessCount
+	}
+	item := &cacheItem{Value: valueBytes, Expiration: nil, AccessCount: accessCount, LastAccess: time.Now(), Size: int64(len(valueBytes)), Checksum: checksum(valueBytes)} // No expiration
+	store.data[key] = item
+	store.totalBytes += int64(len(valueBytes))
+	err = store.persist(walRecord{Op: walOpSet, Key: key, Item: item})
+	store.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	c.publish(CacheEvent{Bucket: store.bucketName, Key: key, Op: OpSet, Size: item.Size})
+	c.enforceByteLimit()
+	return nil
 }
 
 // GetFrozen retrieves the value for the given key from the given bucket without checking for expiration.
 func (c *Cacher) GetFrozen(bucketName string, key string, out interface{}) (bool, error) {
-	store, err := c.getStore(bucketName)
+	group, err := c.getGroup(bucketName)
 	if err != nil {
 		return false, err
 	}
+	store := group.shardFor(key)
 	store.mu.Lock()
 	defer store.mu.Unlock()
 	item, ok := store.data[key]
 	if !ok {
+		atomic.AddUint64(&c.misses, 1)
 		return false, nil
 	}
-	data, err := json.Marshal(item.Value)
-	if err != nil {
-		return false, err
+	if !checksumMatches(item) {
+		delete(store.data, key)
+		store.totalBytes -= item.Size
+		atomic.AddUint64(&c.corruptions, 1)
+		_ = store.saveToFile()
+		return false, fmt.Errorf("filecache: item %q in bucket %q: %w", key, bucketName, ErrCorrupt)
 	}
-	return true, json.Unmarshal(data, out)
+	item.AccessCount++
+	item.LastAccess = time.Now()
+	atomic.AddUint64(&c.hits, 1)
+	c.publish(CacheEvent{Bucket: store.bucketName, Key: key, Op: OpGet, Size: item.Size})
+	return true, store.codec.Unmarshal(item.Value, out)
 }
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
+// fileHeaderPrefix marks the start of a cache file with the FileExt of the
+// Codec that wrote it, so loadFromFile can reject a file written by a
+// different codec instead of silently misreading its bytes.
+const fileHeaderPrefix = "filecache:"
+
 func (cs *CacheStore) loadFromFile() error {
 	file, err := os.Open(cs.filePath)
 	if err != nil {
@@ -311,28 +751,255 @@ func (cs *CacheStore) loadFromFile() error {
 	}
 	defer file.Close()
 
-	if err := json.NewDecoder(file).Decode(&cs.data); err != nil {
+	raw, err := io.ReadAll(file)
+	if err != nil {
+		return fmt.Errorf("filecache: failed to read cache file: %w", err)
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+
+	// The trailing sha256 sum covers the header and payload written by
+	// saveToFile. A crash mid-write (or any other truncation/corruption)
+	// leaves a file that fails this check; rather than surfacing a generic
+	// decode error, treat the bucket as empty and let it rebuild.
+	if len(raw) < sha256.Size {
+		return nil
+	}
+	body, trailer := raw[:len(raw)-sha256.Size], raw[len(raw)-sha256.Size:]
+	sum := sha256.Sum256(body)
+	if !bytes.Equal(sum[:], trailer) {
+		return nil
+	}
+
+	nl := bytes.IndexByte(body, '\n')
+	if nl < 0 || !bytes.HasPrefix(body, []byte(fileHeaderPrefix)) {
+		return nil
+	}
+	ext := string(body[len(fileHeaderPrefix):nl])
+	if ext != cs.codec.FileExt() {
+		return fmt.Errorf("filecache: %s was written with codec %q, this Cacher uses %q: %w", cs.filePath, ext, cs.codec.FileExt(), ErrCodecMismatch)
+	}
+
+	if err := cs.codec.Unmarshal(body[nl+1:], &cs.data); err != nil {
 		return fmt.Errorf("filecache: failed to decode cache data: %w", err)
 	}
+
+	for _, item := range cs.data {
+		if item.Size == 0 {
+			item.Size = int64(len(item.Value))
+		}
+		cs.totalBytes += item.Size
+	}
 	return nil
 }
 
+// saveToFile rewrites the bucket's whole snapshot to cs.filePath via a
+// write-to-temp, fsync, then rename sequence, so a crash mid-write leaves
+// the previous snapshot intact instead of a truncated or zero-byte file
+// (os.Rename is atomic within the same directory). If WAL mode is enabled,
+// the snapshot now written fully reflects cs.data, so the WAL is truncated
+// away behind it.
 func (cs *CacheStore) saveToFile() error {
-	file, err := os.Create(cs.filePath)
-	if err != nil {
-		return fmt.Errorf("filecache: failed to create cache file: %w", err)
+	var buf bytes.Buffer
+	if _, err := fmt.Fprintf(&buf, "%s%s\n", fileHeaderPrefix, cs.codec.FileExt()); err != nil {
+		return fmt.Errorf("filecache: failed to write cache file header: %w", err)
 	}
-	defer file.Close()
 
-	if err := json.NewEncoder(file).Encode(cs.data); err != nil {
+	encoded, err := cs.codec.Marshal(nil, cs.data)
+	if err != nil {
 		return fmt.Errorf("filecache: failed to encode cache data: %w", err)
 	}
+	buf.Write(encoded)
+
+	trailer := sha256.Sum256(buf.Bytes())
+	buf.Write(trailer[:])
+
+	tmp, err := os.CreateTemp(filepath.Dir(cs.filePath), filepath.Base(cs.filePath)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("filecache: failed to create temp cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("filecache: failed to write cache data: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("filecache: failed to sync cache data: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("filecache: failed to close temp cache file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), cs.filePath); err != nil {
+		return fmt.Errorf("filecache: failed to replace cache file: %w", err)
+	}
+
+	return cs.resetWAL()
+}
+
+// resetWAL discards the WAL file and any open handle to it, called once a
+// full snapshot covering cs.data has been written so the WAL's records are
+// no longer needed to reconstruct state.
+func (cs *CacheStore) resetWAL() error {
+	if !cs.walEnabled {
+		return nil
+	}
+	if cs.walFile != nil {
+		if err := cs.walFile.Close(); err != nil {
+			return fmt.Errorf("filecache: failed to close WAL file: %w", err)
+		}
+		cs.walFile = nil
+	}
+	if err := os.Remove(cs.walPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("filecache: failed to remove WAL file: %w", err)
+	}
+	cs.walSize = 0
+	return nil
+}
+
+// walOp identifies the kind of change a walRecord represents.
+type walOp byte
+
+const (
+	walOpSet walOp = iota + 1
+	walOpDelete
+)
+
+// walRecord is a single WAL entry appended by persist and replayed by
+// replayWAL. Item is nil for walOpDelete.
+type walRecord struct {
+	Op   walOp
+	Key  string
+	Item *cacheItem
+}
+
+// persist applies a single Set/Delete change. With WAL mode disabled it
+// rewrites the whole snapshot, as saveToFile always has. With WAL mode
+// enabled it appends rec to the WAL instead, only paying the full rewrite
+// once the WAL has grown past walThreshold.
+func (cs *CacheStore) persist(rec walRecord) error {
+	if !cs.walEnabled {
+		return cs.saveToFile()
+	}
+	if err := cs.appendWAL(rec); err != nil {
+		return err
+	}
+	if cs.walSize >= cs.walThreshold {
+		return cs.saveToFile()
+	}
+	return nil
+}
+
+// appendWAL appends a length-prefixed, codec-encoded rec to cs.walPath and
+// fsyncs it before returning, so a record is only ever considered durable
+// once it is safely on disk.
+func (cs *CacheStore) appendWAL(rec walRecord) error {
+	if cs.walFile == nil {
+		file, err := os.OpenFile(cs.walPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return fmt.Errorf("filecache: failed to open WAL file: %w", err)
+		}
+		cs.walFile = file
+	}
+
+	encoded, err := cs.codec.Marshal(nil, rec)
+	if err != nil {
+		return fmt.Errorf("filecache: failed to encode WAL record: %w", err)
+	}
+
+	var lenPrefix [4]byte
+	binary.BigEndian.PutUint32(lenPrefix[:], uint32(len(encoded)))
+	if _, err := cs.walFile.Write(lenPrefix[:]); err != nil {
+		return fmt.Errorf("filecache: failed to append WAL record: %w", err)
+	}
+	if _, err := cs.walFile.Write(encoded); err != nil {
+		return fmt.Errorf("filecache: failed to append WAL record: %w", err)
+	}
+	if err := cs.walFile.Sync(); err != nil {
+		return fmt.Errorf("filecache: failed to sync WAL file: %w", err)
+	}
+
+	cs.walSize += int64(len(lenPrefix)) + int64(len(encoded))
+	return nil
+}
+
+// replayWAL applies every record in cs.walPath on top of the snapshot
+// already loaded by loadFromFile, then reopens the file for appending so
+// subsequent Set/Delete calls continue from where the WAL left off. A
+// trailing record left truncated by a crash mid-append is detected by its
+// length prefix running past the end of the file and is discarded, matching
+// the length-prefixed framing's whole purpose: a partial append never
+// corrupts the records before it.
+func (cs *CacheStore) replayWAL() error {
+	raw, err := os.ReadFile(cs.walPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("filecache: failed to read WAL file: %w", err)
+	}
+
+	var valid int
+	for valid < len(raw) {
+		rest := raw[valid:]
+		if len(rest) < 4 {
+			break
+		}
+		n := binary.BigEndian.Uint32(rest[:4])
+		if uint64(len(rest)-4) < uint64(n) {
+			break
+		}
+		encoded := rest[4 : 4+n]
+
+		var rec walRecord
+		if err := cs.codec.Unmarshal(encoded, &rec); err != nil {
+			return fmt.Errorf("filecache: failed to decode WAL record: %w", err)
+		}
+
+		switch rec.Op {
+		case walOpSet:
+			if old, ok := cs.data[rec.Key]; ok {
+				cs.totalBytes -= old.Size
+			}
+			cs.data[rec.Key] = rec.Item
+			cs.totalBytes += rec.Item.Size
+		case walOpDelete:
+			if old, ok := cs.data[rec.Key]; ok {
+				cs.totalBytes -= old.Size
+				delete(cs.data, rec.Key)
+			}
+		}
+
+		valid += 4 + int(n)
+	}
+
+	// A trailing partial record (a crash mid-append) is truncated away so a
+	// subsequent append can't be misread as a continuation of the garbage
+	// left behind it.
+	if valid < len(raw) {
+		if err := os.Truncate(cs.walPath, int64(valid)); err != nil {
+			return fmt.Errorf("filecache: failed to truncate WAL file: %w", err)
+		}
+	}
+
+	file, err := os.OpenFile(cs.walPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("filecache: failed to open WAL file: %w", err)
+	}
+	cs.walFile = file
+	cs.walSize = int64(valid)
 	return nil
 }
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
-// RemoveAllBy removes all files in the cache directory that match the given filter.
+// RemoveAllBy removes all files in the cache directory that match the given
+// filter. On a namespaced Cacher (see Namespace) only files belonging to
+// this namespace are considered, and only this namespace's entries are
+// dropped from the shared store cache, so one namespace's RemoveAllBy can't
+// delete another namespace's buckets.
 func (c *Cacher) RemoveAllBy(filter func(filename string) bool) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -345,6 +1012,9 @@ func (c *Cacher) RemoveAllBy(filter func(filename string) bool) error {
 			if !strings.HasSuffix(info.Name(), Ext) {
 				return nil
 			}
+			if !strings.HasPrefix(info.Name(), c.nsPrefix) {
+				return nil
+			}
 			if filter(info.Name()) {
 				if err := os.Remove(filepath.Join(c.dir, info.Name())); err != nil {
 					return fmt.Errorf("filecache: failed to remove file: %w", err)
@@ -354,12 +1024,17 @@ func (c *Cacher) RemoveAllBy(filter func(filename string) bool) error {
 		return nil
 	})
 
-	c.stores = make(map[string]*CacheStore)
+	for name := range c.stores {
+		if strings.HasPrefix(name, c.nsPrefix) {
+			delete(c.stores, name)
+		}
+	}
 	return err
 }
 
 // GetTotalSize returns the total size of all files in the cache directory that match the given filter.
-// The size is in bytes.
+// The size is in bytes. On a namespaced Cacher (see Namespace) only files
+// belonging to this namespace are counted.
 func (c *Cacher) GetTotalSize() (int64, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -369,7 +1044,7 @@ func (c *Cacher) GetTotalSize() (int64, error) {
 		if err != nil {
 			return err
 		}
-		if !info.IsDir() {
+		if !info.IsDir() && strings.HasPrefix(info.Name(), c.nsPrefix) {
 			totalSize += info.Size()
 		}
 		return nil
@@ -1,8 +1,13 @@
 package filecache
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -95,3 +100,400 @@ func TestCacherSetAndGet(t *testing.T) {
 	wg.Wait()
 
 }
+
+func TestCacherEvictsUnderByteLimit(t *testing.T) {
+
+	tempDir := t.TempDir()
+
+	cacher, err := NewCacherWithLimits(tempDir, 200, LFU)
+	if err != nil {
+		t.Fatalf("Failed to create cacher: %v", err)
+	}
+
+	bucket := NewBucket("evict", time.Minute)
+
+	// Write enough keys that the total serialized size exceeds the 200 byte budget.
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if err := cacher.Set(bucket.Name(), bucket.TTL(), key, testStruct{Name: key}); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+	}
+
+	// Access key19 repeatedly. The earliest keys were already evicted during
+	// the Sets above: under LFU all items tied at zero accesses, so the
+	// oldest access (key0 first) went first.
+	var out testStruct
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("key%d", 19)
+		_, _ = cacher.Get(bucket.Name(), key, &out)
+	}
+
+	found, err := cacher.Get(bucket.Name(), "key0", &out)
+	if err != nil {
+		t.Fatalf("Failed to get key0: %v", err)
+	}
+	if found {
+		t.Fatalf("Expected key0 to have been evicted under the byte limit")
+	}
+
+	stats := cacher.Stats()
+	if stats.Evictions == 0 {
+		t.Fatalf("Expected at least one eviction to be recorded in Stats()")
+	}
+}
+
+func TestCacherWALPersistsAcrossReopen(t *testing.T) {
+
+	tempDir := t.TempDir()
+
+	cacher, err := NewCacherWithWAL(tempDir, 1<<20)
+	if err != nil {
+		t.Fatalf("Failed to create cacher: %v", err)
+	}
+
+	bucket := NewBucket("test", time.Minute)
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if err := cacher.Set(bucket.Name(), bucket.TTL(), key, testStruct{Name: key}); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+	}
+	if err := cacher.Delete(bucket.Name(), "key0"); err != nil {
+		t.Fatalf("Failed to delete key0: %v", err)
+	}
+
+	// A fresh Cacher over the same directory, without Close having been
+	// called, should replay the WAL on top of the (empty) snapshot.
+	reopened, err := NewCacherWithWAL(tempDir, 1<<20)
+	if err != nil {
+		t.Fatalf("Failed to reopen cacher: %v", err)
+	}
+
+	var out testStruct
+	found, err := reopened.Get(bucket.Name(), "key0", &out)
+	if err != nil {
+		t.Fatalf("Failed to get key0: %v", err)
+	}
+	if found {
+		t.Fatalf("Expected key0 to have been deleted")
+	}
+
+	found, err = reopened.Get(bucket.Name(), "key5", &out)
+	if err != nil {
+		t.Fatalf("Failed to get key5: %v", err)
+	}
+	if !found || out.Name != "key5" {
+		t.Fatalf("Failed to get the correct value for key5. Got %v", out)
+	}
+}
+
+func TestGetOrLoadCollapsesConcurrentMisses(t *testing.T) {
+
+	tempDir := t.TempDir()
+
+	cacher, err := NewCacher(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create cacher: %v", err)
+	}
+
+	bucket := NewBucket("test", time.Minute)
+
+	var loads int64
+	loader := func(ctx context.Context) (testStruct, error) {
+		atomic.AddInt64(&loads, 1)
+		time.Sleep(50 * time.Millisecond)
+		return testStruct{Name: "loaded"}, nil
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, err := GetOrLoad(cacher, bucket.Name(), bucket.TTL(), "key", loader)
+			if err != nil {
+				t.Errorf("GetOrLoad failed: %v", err)
+				return
+			}
+			if out.Name != "loaded" {
+				t.Errorf("Expected loaded value, got %v", out)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if loads != 1 {
+		t.Fatalf("Expected the loader to run exactly once, ran %d times", loads)
+	}
+
+	var out testStruct
+	found, err := cacher.Get(bucket.Name(), "key", &out)
+	if err != nil || !found || out.Name != "loaded" {
+		t.Fatalf("Expected the loaded value to have been cached, got found=%v err=%v out=%v", found, err, out)
+	}
+}
+
+func TestGetOrLoadPropagatesLoaderError(t *testing.T) {
+
+	tempDir := t.TempDir()
+
+	cacher, err := NewCacher(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create cacher: %v", err)
+	}
+
+	bucket := NewBucket("test", time.Minute)
+	loadErr := errors.New("upstream unavailable")
+
+	_, err = GetOrLoad(cacher, bucket.Name(), bucket.TTL(), "key", func(ctx context.Context) (testStruct, error) {
+		return testStruct{}, loadErr
+	})
+	if !errors.Is(err, loadErr) {
+		t.Fatalf("Expected loader error to be propagated, got %v", err)
+	}
+
+	var out testStruct
+	found, err := cacher.Get(bucket.Name(), "key", &out)
+	if err != nil || found {
+		t.Fatalf("Expected nothing to have been cached after a loader error, found=%v err=%v", found, err)
+	}
+}
+
+func TestCacherWithOptionsCodecs(t *testing.T) {
+
+	codecs := map[string]Codec{
+		"json":           JSONCodec,
+		"gob":            GobCodec,
+		"msgpack":        MsgpackCodec,
+		"compressed-gob": CompressedCodec{Codec: GobCodec},
+	}
+
+	for name, codec := range codecs {
+		codec := codec
+		t.Run(name, func(t *testing.T) {
+			tempDir := t.TempDir()
+
+			cacher, err := NewCacherWithOptions(tempDir, WithCodec(codec))
+			if err != nil {
+				t.Fatalf("Failed to create cacher: %v", err)
+			}
+
+			bucket := NewBucket("test", time.Minute)
+			value := testStruct{Name: "value"}
+
+			if err := cacher.Set(bucket.Name(), bucket.TTL(), "key", value); err != nil {
+				t.Fatalf("Failed to set the value: %v", err)
+			}
+
+			var out testStruct
+			found, err := cacher.Get(bucket.Name(), "key", &out)
+			if err != nil {
+				t.Fatalf("Failed to get the value: %v", err)
+			}
+			if !found || out != value {
+				t.Fatalf("Failed to get the correct value. Expected %v, got %v", value, out)
+			}
+		})
+	}
+}
+
+func TestCacherDetectsCorruption(t *testing.T) {
+
+	tempDir := t.TempDir()
+
+	cacher, err := NewCacher(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create cacher: %v", err)
+	}
+
+	bucket := NewBucket("test", time.Minute)
+	if err := cacher.Set(bucket.Name(), bucket.TTL(), "key", testStruct{Name: "value"}); err != nil {
+		t.Fatalf("Failed to set the value: %v", err)
+	}
+
+	group, err := cacher.getGroup(bucket.Name())
+	if err != nil {
+		t.Fatalf("Failed to get the store: %v", err)
+	}
+	store := group.shardFor("key")
+	store.mu.Lock()
+	store.data["key"].Value[0] ^= 0xFF
+	store.mu.Unlock()
+
+	var out testStruct
+	found, err := cacher.Get(bucket.Name(), "key", &out)
+	if found {
+		t.Fatalf("Expected a corrupted item not to be returned")
+	}
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Expected ErrCorrupt, got %v", err)
+	}
+
+	if stats := cacher.Stats(); stats.Corruptions == 0 {
+		t.Fatalf("Expected at least one corruption to be recorded in Stats()")
+	}
+
+	// The corrupt item should have been evicted, so a retry reports a miss
+	// rather than repeating the corruption error.
+	found, err = cacher.Get(bucket.Name(), "key", &out)
+	if found || err != nil {
+		t.Fatalf("Expected a clean miss after the corrupt item was evicted, got found=%v err=%v", found, err)
+	}
+}
+
+func TestCacherShardedBucketSplitsAcrossFiles(t *testing.T) {
+
+	tempDir := t.TempDir()
+
+	cacher, err := NewCacher(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create cacher: %v", err)
+	}
+
+	bucket := NewShardedBucket("sharded", time.Minute, 4)
+	cacher.UseBucket(bucket)
+
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if err := cacher.Set(bucket.Name(), bucket.TTL(), key, testStruct{Name: key}); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+	}
+
+	matches, err := filepath.Glob(filepath.Join(tempDir, bucket.Name()+".*.cache"))
+	if err != nil {
+		t.Fatalf("Failed to glob shard files: %v", err)
+	}
+	if len(matches) != 4 {
+		t.Fatalf("Expected 4 shard files, got %d: %v", len(matches), matches)
+	}
+
+	if _, err := os.Stat(filepath.Join(tempDir, bucket.Name()+".shards")); err != nil {
+		t.Fatalf("Expected a shard manifest file: %v", err)
+	}
+
+	// A second Cacher opened against the same directory should detect the
+	// shard count from the manifest without being told again.
+	reopened, err := NewCacher(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to reopen cacher: %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key%d", i)
+		var out testStruct
+		found, err := reopened.Get(bucket.Name(), key, &out)
+		if err != nil {
+			t.Fatalf("Failed to get %s: %v", key, err)
+		}
+		if !found || out.Name != key {
+			t.Fatalf("Failed to get the correct value for %s. Got %v", key, out)
+		}
+	}
+}
+
+func TestCacherRejectsMismatchedCodec(t *testing.T) {
+
+	tempDir := t.TempDir()
+
+	jsonCacher, err := NewCacherWithOptions(tempDir, WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatalf("Failed to create cacher: %v", err)
+	}
+
+	bucket := NewBucket("test", time.Minute)
+	if err := jsonCacher.Set(bucket.Name(), bucket.TTL(), "key", testStruct{Name: "value"}); err != nil {
+		t.Fatalf("Failed to set the value: %v", err)
+	}
+
+	gobCacher, err := NewCacherWithOptions(tempDir, WithCodec(GobCodec))
+	if err != nil {
+		t.Fatalf("Failed to create cacher: %v", err)
+	}
+
+	var out testStruct
+	_, err = gobCacher.Get(bucket.Name(), "key", &out)
+	if !errors.Is(err, ErrCodecMismatch) {
+		t.Fatalf("Expected ErrCodecMismatch, got %v", err)
+	}
+}
+
+func TestNamespaceIsolatesBucketsFromSharedCacher(t *testing.T) {
+
+	tempDir := t.TempDir()
+
+	cacher, err := NewCacher(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create cacher: %v", err)
+	}
+
+	auth := cacher.Namespace("auth")
+	billing := cacher.Namespace("billing")
+
+	if err := auth.Set("sessions", time.Minute, "key", testStruct{Name: "auth-value"}); err != nil {
+		t.Fatalf("Failed to set in auth namespace: %v", err)
+	}
+	if err := billing.Set("sessions", time.Minute, "key", testStruct{Name: "billing-value"}); err != nil {
+		t.Fatalf("Failed to set in billing namespace: %v", err)
+	}
+
+	var authOut, billingOut testStruct
+	if found, err := auth.Get("sessions", "key", &authOut); err != nil || !found || authOut.Name != "auth-value" {
+		t.Fatalf("Expected auth-value, got %v (found=%v, err=%v)", authOut, found, err)
+	}
+	if found, err := billing.Get("sessions", "key", &billingOut); err != nil || !found || billingOut.Name != "billing-value" {
+		t.Fatalf("Expected billing-value, got %v (found=%v, err=%v)", billingOut, found, err)
+	}
+
+	// The root Cacher sees each namespace's bucket under its prefixed name.
+	var rootOut testStruct
+	if found, err := cacher.Get("auth:sessions", "key", &rootOut); err != nil || !found || rootOut.Name != "auth-value" {
+		t.Fatalf("Expected to find the prefixed bucket name from the root Cacher, got %v (found=%v, err=%v)", rootOut, found, err)
+	}
+}
+
+func TestCacherSubscribeReceivesEvents(t *testing.T) {
+
+	tempDir := t.TempDir()
+
+	cacher, err := NewCacher(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create cacher: %v", err)
+	}
+
+	var mu sync.Mutex
+	var events []CacheEvent
+	done := make(chan struct{})
+	cacher.Subscribe(func(event CacheEvent) {
+		mu.Lock()
+		events = append(events, event)
+		if len(events) == 2 {
+			close(done)
+		}
+		mu.Unlock()
+	})
+
+	bucket := NewBucket("events", time.Minute)
+	if err := cacher.Set(bucket.Name(), bucket.TTL(), "key", testStruct{Name: "value"}); err != nil {
+		t.Fatalf("Failed to set the value: %v", err)
+	}
+	var out testStruct
+	if _, err := cacher.Get(bucket.Name(), "key", &out); err != nil {
+		t.Fatalf("Failed to get the value: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for subscriber to receive both events")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if events[0].Op != OpSet || events[0].Bucket != "events" || events[0].Key != "key" {
+		t.Fatalf("Unexpected first event: %+v", events[0])
+	}
+	if events[1].Op != OpGet || events[1].Bucket != "events" || events[1].Key != "key" {
+		t.Fatalf("Unexpected second event: %+v", events[1])
+	}
+}
@@ -0,0 +1,91 @@
+package filecache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// call represents a single in-flight GetOrLoad call. Waiters block on wg and
+// then read val/err, which are only written before wg.Done() is called, so
+// no further synchronization is needed to read them safely.
+type call struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+// GetOrLoad retrieves the value for key from bucket, calling loader to
+// populate it on a miss or expired entry. Concurrent GetOrLoad calls for the
+// same bucket and key collapse onto a single in-flight loader call: only one
+// goroutine runs loader while the others block on its result, which avoids a
+// thundering herd of identical requests against a slow upstream. On success
+// the loaded value is stored via Set with the given ttl before being
+// returned; on failure the error is returned to every waiter and nothing is
+// cached.
+func GetOrLoad[T any](c *Cacher, bucketName string, ttl time.Duration, key string, loader func(ctx context.Context) (T, error)) (T, error) {
+	var out T
+	// A Get error (e.g. ErrCorrupt) already removed the offending item, so
+	// it is treated the same as a plain miss: fall through to the loader.
+	if found, err := c.Get(bucketName, key, &out); err == nil && found {
+		return out, nil
+	}
+
+	group, err := c.getGroup(bucketName)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	store := group.shardFor(key)
+
+	store.mu.Lock()
+	if existing, ok := store.calls[key]; ok {
+		store.mu.Unlock()
+		existing.wg.Wait()
+		if existing.err != nil {
+			var zero T
+			return zero, existing.err
+		}
+		return existing.val.(T), nil
+	}
+
+	cl := &call{}
+	cl.wg.Add(1)
+	if store.calls == nil {
+		store.calls = make(map[string]*call)
+	}
+	store.calls[key] = cl
+	store.mu.Unlock()
+
+	val, err := loader(context.Background())
+
+	// store.calls keeps this key's entry until the result is fully visible
+	// as cached (or, on failure, until every waiter has been woken), so a
+	// caller arriving in the meantime waits on cl instead of finding no
+	// entry, treating it as a miss, and launching a second loader call.
+	if err != nil {
+		cl.err = err
+		cl.wg.Done()
+		store.mu.Lock()
+		delete(store.calls, key)
+		store.mu.Unlock()
+		var zero T
+		return zero, err
+	}
+
+	if err := c.Set(bucketName, ttl, key, val); err != nil {
+		cl.err = err
+		cl.wg.Done()
+		store.mu.Lock()
+		delete(store.calls, key)
+		store.mu.Unlock()
+		return val, err
+	}
+
+	cl.val = val
+	cl.wg.Done()
+	store.mu.Lock()
+	delete(store.calls, key)
+	store.mu.Unlock()
+	return val, nil
+}
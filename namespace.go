@@ -0,0 +1,17 @@
+package filecache
+
+// Namespace returns a view of c in which every bucket name is transparently
+// prefixed, so independent components (e.g. separate libraries embedding
+// filecache) sharing one Cacher can't collide on bucket names without
+// coordinating them. The returned Cacher shares the same underlying storage,
+// stats, and subscribers as c; it is a naming convenience, not a separate
+// cache. Namespaces nest: ns.Namespace("inner") prefixes with both ns's and
+// "inner"'s prefixes. RemoveBucket, RemoveAllBy, and GetTotalSize are all
+// scoped to the calling Cacher's prefix, so calling them on a namespaced
+// Cacher only ever touches that namespace's buckets.
+func (c *Cacher) Namespace(prefix string) *Cacher {
+	return &Cacher{
+		cacherCore: c.cacherCore,
+		nsPrefix:   c.nsPrefix + prefix + ":",
+	}
+}
@@ -0,0 +1,143 @@
+package filecache
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// shardManifestExt names the small per-bucket file that records how many
+// shards a sharded bucket was created with, so that count is detected
+// consistently on every subsequent load regardless of what a caller passes
+// to UseBucket. Unsharded buckets (the default) have no manifest file.
+const shardManifestExt = ".shards"
+
+// bucketGroup is the set of CacheStore shards backing a single bucket. An
+// unsharded bucket (the default, and every bucket that existed before
+// sharding was introduced) is a bucketGroup with exactly one shard, stored
+// under the bucket's original, un-suffixed filename.
+type bucketGroup struct {
+	shards []*CacheStore
+}
+
+// shardFor returns the shard a key belongs to, selected by FNV-1a of the key
+// modulo the shard count so a given key always lands on the same shard.
+func (g *bucketGroup) shardFor(key string) *CacheStore {
+	if len(g.shards) == 1 {
+		return g.shards[0]
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return g.shards[h.Sum32()%uint32(len(g.shards))]
+}
+
+// shardFileName returns the on-disk cache file name for shard index of a
+// bucket with the given total shard count. An unsharded bucket keeps the
+// plain "<bucket>.cache" name so existing single-file buckets stay readable
+// after upgrading to a Cacher that supports sharding.
+func shardFileName(bucketName string, index, shards int) string {
+	if shards <= 1 {
+		return bucketName + ".cache"
+	}
+	return fmt.Sprintf("%s.%d.cache", bucketName, index)
+}
+
+// shardWALName is shardFileName's WAL-mode equivalent.
+func shardWALName(bucketName string, index, shards int) string {
+	if shards <= 1 {
+		return bucketName + ".wal"
+	}
+	return fmt.Sprintf("%s.%d.wal", bucketName, index)
+}
+
+// readShardManifest returns the shard count recorded for a bucket, or 0 if
+// it has no manifest file (i.e. it is unsharded or has never been created).
+func readShardManifest(path string) (int, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("filecache: failed to read shard manifest: %w", err)
+	}
+	shards, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return 0, fmt.Errorf("filecache: invalid shard manifest %s: %w", path, err)
+	}
+	return shards, nil
+}
+
+func writeShardManifest(path string, shards int) error {
+	if err := os.WriteFile(path, []byte(strconv.Itoa(shards)), 0o644); err != nil {
+		return fmt.Errorf("filecache: failed to write shard manifest: %w", err)
+	}
+	return nil
+}
+
+// getGroup returns the bucketGroup for the given bucket, loading it (and,
+// the first time a sharded bucket is created, writing its manifest) if it
+// isn't already open. The shard count is decided in this order: the
+// bucket's existing manifest file, then 1 if the bucket already has an
+// unsharded cache or WAL file on disk, then a pending count set via
+// UseBucket, then 1 (unsharded).
+func (c *Cacher) getGroup(name string) (*bucketGroup, error) {
+	name = c.nsPrefix + name
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if group, ok := c.stores[name]; ok {
+		return group, nil
+	}
+
+	manifestPath := filepath.Join(c.dir, name+shardManifestExt)
+	shards, err := readShardManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	manifestExisted := shards > 0
+	if shards == 0 {
+		_, snapErr := os.Stat(filepath.Join(c.dir, shardFileName(name, 0, 1)))
+		_, walErr := os.Stat(filepath.Join(c.dir, shardWALName(name, 0, 1)))
+		if os.IsNotExist(snapErr) && os.IsNotExist(walErr) {
+			shards = c.pendingShards[name]
+		}
+	}
+	if shards < 1 {
+		shards = 1
+	}
+
+	group := &bucketGroup{shards: make([]*CacheStore, shards)}
+	for i := 0; i < shards; i++ {
+		store := &CacheStore{
+			bucketName:   name,
+			filePath:     filepath.Join(c.dir, shardFileName(name, i, shards)),
+			data:         make(map[string]*cacheItem),
+			codec:        c.codec,
+			walEnabled:   c.wal,
+			walThreshold: c.walThreshold,
+			walPath:      filepath.Join(c.dir, shardWALName(name, i, shards)),
+		}
+		if err := store.loadFromFile(); err != nil {
+			return nil, err
+		}
+		if store.walEnabled {
+			if err := store.replayWAL(); err != nil {
+				return nil, err
+			}
+		}
+		group.shards[i] = store
+	}
+
+	if shards > 1 && !manifestExisted {
+		if err := writeShardManifest(manifestPath, shards); err != nil {
+			return nil, err
+		}
+	}
+
+	c.stores[name] = group
+	return group, nil
+}
@@ -0,0 +1,5 @@
+package filecache
+
+func ToPtr[T any](v T) *T {
+	return &v
+}